@@ -0,0 +1,103 @@
+package caddys3proxy
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// sniffLen mirrors http.DetectContentType's read requirement.
+const sniffLen = 512
+
+// sniffedBody re-assembles the bytes consumed for content-type sniffing back
+// onto the front of the stream, so the object body can still be copied to
+// the client in full.
+type sniffedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// extensionContentType looks up an override for key's file extension in
+// p.ExtensionMap, e.g. ".wasm" -> "application/wasm".
+func (p S3Proxy) extensionContentType(key string) (string, bool) {
+	if len(p.ExtensionMap) == 0 {
+		return "", false
+	}
+	ct, ok := p.ExtensionMap[path.Ext(key)]
+	return ct, ok
+}
+
+// isGenericContentType reports whether ct is missing or too generic to
+// trust, and should be replaced by sniffing the object body instead.
+func (p S3Proxy) isGenericContentType(ct string) bool {
+	if ct == "" {
+		return true
+	}
+	for _, generic := range p.GenericContentTypes {
+		if ct == generic {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffContentType reads up to the first 512 bytes of body to detect its
+// Content-Type via http.DetectContentType, then returns a reader that
+// replays those bytes before the rest of body.
+func sniffContentType(body io.ReadCloser) (string, io.ReadCloser, error) {
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(body, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", body, err
+	}
+	buf = buf[:n]
+
+	contentType := http.DetectContentType(buf)
+	combined := sniffedBody{
+		Reader: io.MultiReader(strings.NewReader(string(buf)), body),
+		Closer: body,
+	}
+	return contentType, combined, nil
+}
+
+// acceptsGzip reports whether the client's Accept-Encoding header includes gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if strings.EqualFold(strings.TrimSpace(enc), "gzip") {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipETag derives an ETag for the gzip-transcoded representation of an
+// object, distinguishing it from the identity-encoded ETag returned by S3.
+func gzipETag(etag string) string {
+	if strings.HasSuffix(etag, `"`) {
+		return strings.TrimSuffix(etag, `"`) + `-gzip"`
+	}
+	return etag + "-gzip"
+}
+
+// gzipWriterPool reuses gzip.Writer values across requests.
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(io.Discard) },
+}
+
+// gzipCopy gzips body on the fly into w using a pooled gzip.Writer.
+func gzipCopy(w io.Writer, body io.ReadCloser) error {
+	gw := gzipWriterPool.Get().(*gzip.Writer)
+	gw.Reset(w)
+	defer func() {
+		gw.Reset(io.Discard)
+		gzipWriterPool.Put(gw)
+	}()
+
+	if _, err := io.Copy(gw, body); err != nil {
+		return err
+	}
+	return gw.Close()
+}
@@ -0,0 +1,107 @@
+package caddys3proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExtensionContentType(t *testing.T) {
+	p := S3Proxy{ExtensionMap: map[string]string{".wasm": "application/wasm"}}
+
+	if ct, ok := p.extensionContentType("/app.wasm"); !ok || ct != "application/wasm" {
+		t.Fatalf("extensionContentType(/app.wasm) = (%q, %v), want (application/wasm, true)", ct, ok)
+	}
+	if _, ok := p.extensionContentType("/app.txt"); ok {
+		t.Fatal("extensionContentType(/app.txt) matched, want no match")
+	}
+}
+
+func TestExtensionContentTypeEmptyMap(t *testing.T) {
+	p := S3Proxy{}
+	if _, ok := p.extensionContentType("/app.wasm"); ok {
+		t.Fatal("extensionContentType with no ExtensionMap matched, want no match")
+	}
+}
+
+func TestIsGenericContentType(t *testing.T) {
+	p := S3Proxy{GenericContentTypes: []string{"application/octet-stream"}}
+
+	if !p.isGenericContentType("") {
+		t.Fatal("empty Content-Type should be considered generic")
+	}
+	if !p.isGenericContentType("application/octet-stream") {
+		t.Fatal("application/octet-stream should be considered generic")
+	}
+	if p.isGenericContentType("application/wasm") {
+		t.Fatal("application/wasm should not be considered generic")
+	}
+}
+
+func TestSniffContentType(t *testing.T) {
+	body := io.NopCloser(bytes.NewReader([]byte("<html><body>hi</body></html>")))
+
+	ct, combined, err := sniffContentType(body)
+	if err != nil {
+		t.Fatalf("sniffContentType returned error: %v", err)
+	}
+	if ct != "text/html; charset=utf-8" {
+		t.Fatalf("detected Content-Type = %q, want text/html; charset=utf-8", ct)
+	}
+
+	got, err := io.ReadAll(combined)
+	if err != nil {
+		t.Fatalf("reading replayed body: %v", err)
+	}
+	if string(got) != "<html><body>hi</body></html>" {
+		t.Fatalf("replayed body = %q, want the original bytes intact", got)
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	r := httptest.NewRequest("GET", "/key", nil)
+	r.Header.Set("Accept-Encoding", "deflate, gzip")
+	if !acceptsGzip(r) {
+		t.Fatal("expected gzip to be accepted")
+	}
+
+	r2 := httptest.NewRequest("GET", "/key", nil)
+	r2.Header.Set("Accept-Encoding", "deflate")
+	if acceptsGzip(r2) {
+		t.Fatal("expected gzip to not be accepted")
+	}
+}
+
+func TestGzipETag(t *testing.T) {
+	if got, want := gzipETag(`"abc123"`), `"abc123-gzip"`; got != want {
+		t.Fatalf("gzipETag(%q) = %q, want %q", `"abc123"`, got, want)
+	}
+	if got, want := gzipETag("abc123"), "abc123-gzip"; got != want {
+		t.Fatalf("gzipETag(%q) = %q, want %q", "abc123", got, want)
+	}
+}
+
+func TestGzipCopy(t *testing.T) {
+	var buf bytes.Buffer
+	body := io.NopCloser(bytes.NewReader([]byte("hello world")))
+
+	if err := gzipCopy(&buf, body); err != nil {
+		t.Fatalf("gzipCopy returned error: %v", err)
+	}
+
+	gr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("decompressing gzipCopy output: %v", err)
+	}
+	defer gr.Close()
+
+	got, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("reading decompressed body: %v", err)
+	}
+	if string(got) != "hello world" {
+		t.Fatalf("decompressed body = %q, want %q", got, "hello world")
+	}
+}
@@ -0,0 +1,63 @@
+package caddys3proxy
+
+import (
+	"context"
+	"fmt"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// CredentialsConfig selects how the S3 client obtains AWS credentials, as an
+// alternative to relying on the default environment/shared-config chain.
+// Kind is one of "" (default chain), "static", "ec2_instance_role",
+// "web_identity", "profile", or "assume_role".
+type CredentialsConfig struct {
+	Kind            string `json:"kind,omitempty"`
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+	SessionToken    string `json:"session_token,omitempty"`
+	Profile         string `json:"profile,omitempty"`
+	RoleARN         string `json:"role_arn,omitempty"`
+	TokenFile       string `json:"token_file,omitempty"`
+	ExternalID      string `json:"external_id,omitempty"`
+}
+
+// provider builds the aws.CredentialsProvider described by c, or returns a
+// nil provider for the "" kind, leaving the default chain in place. cfg is a
+// bootstrap config (region only, default credential chain) used to construct
+// an STS client for the web_identity and assume_role kinds.
+func (c CredentialsConfig) provider(ctx context.Context, cfg awsv2.Config) (awsv2.CredentialsProvider, error) {
+	switch c.Kind {
+	case "":
+		return nil, nil
+	case "static":
+		return credentials.NewStaticCredentialsProvider(c.AccessKeyID, c.SecretAccessKey, c.SessionToken), nil
+	case "ec2_instance_role":
+		return ec2rolecreds.New(), nil
+	case "profile":
+		profileCfg, err := config.LoadDefaultConfig(ctx, config.WithSharedConfigProfile(c.Profile))
+		if err != nil {
+			return nil, err
+		}
+		return profileCfg.Credentials, nil
+	case "web_identity":
+		return stscreds.NewWebIdentityRoleProvider(
+			sts.NewFromConfig(cfg),
+			c.RoleARN,
+			stscreds.IdentityTokenFile(c.TokenFile),
+		), nil
+	case "assume_role":
+		return stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), c.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			if c.ExternalID != "" {
+				o.ExternalID = awsv2.String(c.ExternalID)
+			}
+		}), nil
+	default:
+		return nil, fmt.Errorf("unknown credentials kind %q", c.Kind)
+	}
+}
@@ -0,0 +1,132 @@
+package caddys3proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+)
+
+// defaultBrowseTemplate is used to render directory listings when no
+// browse_template is configured.
+const defaultBrowseTemplate = `<!DOCTYPE html>
+<html>
+<head><title>Index of {{.Prefix}}</title></head>
+<body>
+<h1>Index of {{.Prefix}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Last Modified</th></tr>
+{{range .CommonPrefixes}}<tr><td><a href="{{.Prefix}}">{{.Prefix}}</a></td><td>-</td><td>-</td></tr>
+{{end}}{{range .Contents}}<tr><td><a href="{{.Key}}">{{.Key}}</a></td><td>{{.Size}}</td><td>{{.LastModified}}</td></tr>
+{{end}}</table>
+{{if .IsTruncated}}<p><a href="?continuation-token={{.NextContinuationToken}}">next page</a></p>{{end}}
+</body>
+</html>
+`
+
+// dirEntry is the view-model for a single S3 object within a directory listing.
+type dirEntry struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	ETag         string
+}
+
+// dirCommonPrefix is the view-model for a "folder" within a directory listing.
+type dirCommonPrefix struct {
+	Prefix string
+}
+
+// dirListing is the view-model passed to dirTemplate (or marshaled to JSON)
+// to render a ListObjectsV2 result as a directory index.
+type dirListing struct {
+	Name                  string
+	Prefix                string
+	Delimiter             string
+	Contents              []dirEntry
+	CommonPrefixes        []dirCommonPrefix
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// ListHandler renders an HTML (or JSON) directory index for fullPath by
+// listing the bucket with fullPath as the prefix and "/" as the delimiter.
+func (p S3Proxy) ListHandler(w http.ResponseWriter, r *http.Request, fullPath string) error {
+	// fullPath is used verbatim as the prefix, matching the key-space every
+	// other handler (GetHandler, PutHandler, ...) stores objects under -
+	// including its leading "/".
+	prefix := fullPath
+	q := r.URL.Query()
+
+	var maxKeys int64 = 1000
+	if mk := q.Get("max-keys"); mk != "" {
+		if n, err := strconv.ParseInt(mk, 10, 64); err == nil && n > 0 {
+			maxKeys = n
+		}
+	}
+
+	loi := &s3.ListObjectsV2Input{
+		Bucket:    awsv2.String(p.Bucket),
+		Prefix:    awsv2.String(prefix),
+		Delimiter: awsv2.String("/"),
+		MaxKeys:   awsv2.Int32(int32(maxKeys)),
+	}
+	if ct := q.Get("continuation-token"); ct != "" {
+		loi.ContinuationToken = awsv2.String(ct)
+	}
+
+	p.log.Debug("browse:attempt",
+		zap.String("bucket", p.Bucket),
+		zap.String("prefix", prefix),
+	)
+
+	out, err := p.client.ListObjectsV2(r.Context(), loi)
+	if err != nil {
+		p.log.Error("browse:fail",
+			zap.String("bucket", p.Bucket),
+			zap.String("prefix", prefix),
+			zap.String("error", err.Error()),
+		)
+		return err
+	}
+
+	listing := dirListing{
+		Name:                  p.Bucket,
+		Prefix:                prefix,
+		Delimiter:             "/",
+		IsTruncated:           awsv2.ToBool(out.IsTruncated),
+		NextContinuationToken: awsv2.ToString(out.NextContinuationToken),
+	}
+	for _, o := range out.Contents {
+		listing.Contents = append(listing.Contents, dirEntry{
+			Key:          strings.TrimPrefix(awsv2.ToString(o.Key), prefix),
+			Size:         awsv2.ToInt64(o.Size),
+			LastModified: awsv2.ToTime(o.LastModified),
+			ETag:         awsv2.ToString(o.ETag),
+		})
+	}
+	for _, cp := range out.CommonPrefixes {
+		listing.CommonPrefixes = append(listing.CommonPrefixes, dirCommonPrefix{
+			Prefix: strings.TrimPrefix(awsv2.ToString(cp.Prefix), prefix),
+		})
+	}
+
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(listing)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return p.dirTemplate.Execute(w, listing)
+}
+
+// wantsJSON reports whether the client asked for a JSON response via the
+// Accept header, so directory listings can be content-negotiated.
+func wantsJSON(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
@@ -0,0 +1,119 @@
+package caddys3proxy
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+// newTestDirListServer returns an httptest.Server standing in for S3,
+// responding to ListObjectsV2 with a fixed ListBucketResult whose keys and
+// prefix live in the leading-slash key-space that PutHandler/GetHandler use.
+func newTestDirListServer(t *testing.T) (*httptest.Server, *string) {
+	t.Helper()
+
+	var gotPrefix string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPrefix = r.URL.Query().Get("prefix")
+
+		result := listBucketResultV2{
+			Xmlns:       s3Namespace,
+			Name:        "bucket",
+			Prefix:      gotPrefix,
+			Delimiter:   "/",
+			MaxKeys:     1000,
+			KeyCount:    2,
+			IsTruncated: false,
+			Contents: []gatewayObject{
+				{
+					Key:          "/photos/cat.png",
+					LastModified: "2024-01-01T00:00:00.000Z",
+					ETag:         `"etag1"`,
+					Size:         123,
+					StorageClass: "STANDARD",
+				},
+			},
+			CommonPrefixes: []gatewayCommonPrefix{
+				{Prefix: "/photos/vacation/"},
+			},
+		}
+
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(xml.Header))
+		xml.NewEncoder(w).Encode(result)
+	}))
+	t.Cleanup(ts.Close)
+
+	return ts, &gotPrefix
+}
+
+func TestListHandlerKeepsLeadingSlashInPrefix(t *testing.T) {
+	ts, gotPrefix := newTestDirListServer(t)
+
+	p := S3Proxy{
+		Bucket:      "bucket",
+		client:      newTestS3Client(ts),
+		dirTemplate: template.Must(template.New("browse").Parse(defaultBrowseTemplate)),
+		log:         zap.NewNop(),
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/photos/", nil)
+	w := httptest.NewRecorder()
+
+	if err := p.ListHandler(w, r, "/photos/"); err != nil {
+		t.Fatalf("ListHandler returned error: %v", err)
+	}
+
+	if *gotPrefix != "/photos/" {
+		t.Fatalf("ListObjectsV2 Prefix = %q, want %q (leading slash preserved)", *gotPrefix, "/photos/")
+	}
+
+	body := w.Body.String()
+	if !strings.Contains(body, "cat.png") {
+		t.Fatalf("listing body missing object entry, got: %s", body)
+	}
+	if !strings.Contains(body, "vacation/") {
+		t.Fatalf("listing body missing common prefix entry, got: %s", body)
+	}
+}
+
+func TestListHandlerJSON(t *testing.T) {
+	ts, _ := newTestDirListServer(t)
+
+	p := S3Proxy{
+		Bucket:      "bucket",
+		client:      newTestS3Client(ts),
+		dirTemplate: template.Must(template.New("browse").Parse(defaultBrowseTemplate)),
+		log:         zap.NewNop(),
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/photos/", nil)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+
+	if err := p.ListHandler(w, r, "/photos/"); err != nil {
+		t.Fatalf("ListHandler returned error: %v", err)
+	}
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Content-Type = %q, want application/json", ct)
+	}
+
+	var listing dirListing
+	if err := json.Unmarshal(w.Body.Bytes(), &listing); err != nil {
+		t.Fatalf("decoding JSON response: %v", err)
+	}
+
+	if len(listing.Contents) != 1 || listing.Contents[0].Key != "cat.png" {
+		t.Fatalf("listing.Contents = %+v, want one entry with key %q (prefix stripped)", listing.Contents, "cat.png")
+	}
+	if len(listing.CommonPrefixes) != 1 || listing.CommonPrefixes[0].Prefix != "vacation/" {
+		t.Fatalf("listing.CommonPrefixes = %+v, want one entry with prefix %q", listing.CommonPrefixes, "vacation/")
+	}
+}
@@ -0,0 +1,52 @@
+package caddys3proxy
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	smithyendpoints "github.com/aws/smithy-go/endpoints"
+)
+
+// s3EndpointResolver wraps the SDK's default S3 endpoint resolution to
+// support non-AWS endpoints (e.g. MinIO, Ceph) where virtual-host style
+// addressing and forced TLS would otherwise break requests.
+type s3EndpointResolver struct {
+	endpoint      string
+	usePathStyle  bool
+	useAccelerate bool
+	disableSSL    bool
+
+	fallback s3.EndpointResolverV2
+}
+
+func newS3EndpointResolver(endpoint string, usePathStyle, useAccelerate, disableSSL bool) *s3EndpointResolver {
+	return &s3EndpointResolver{
+		endpoint:      endpoint,
+		usePathStyle:  usePathStyle,
+		useAccelerate: useAccelerate,
+		disableSSL:    disableSSL,
+		fallback:      s3.NewDefaultEndpointResolverV2(),
+	}
+}
+
+// ResolveEndpoint implements s3.EndpointResolverV2.
+func (r *s3EndpointResolver) ResolveEndpoint(ctx context.Context, params s3.EndpointParameters) (smithyendpoints.Endpoint, error) {
+	if r.endpoint != "" {
+		params.Endpoint = &r.endpoint
+	}
+	params.ForcePathStyle = &r.usePathStyle
+	if r.useAccelerate {
+		params.Accelerate = &r.useAccelerate
+	}
+
+	endpoint, err := r.fallback.ResolveEndpoint(ctx, params)
+	if err != nil {
+		return endpoint, err
+	}
+
+	if r.disableSSL && endpoint.URI.Scheme == "https" {
+		endpoint.URI.Scheme = "http"
+	}
+
+	return endpoint, nil
+}
@@ -0,0 +1,37 @@
+package caddys3proxy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+func TestS3EndpointResolverForcesPathStyle(t *testing.T) {
+	r := newS3EndpointResolver("https://minio.example.com", true, false, false)
+
+	region := "us-east-1"
+	endpoint, err := r.ResolveEndpoint(context.Background(), s3.EndpointParameters{Region: &region})
+	if err != nil {
+		t.Fatalf("ResolveEndpoint returned error: %v", err)
+	}
+	if endpoint.URI.Host != "minio.example.com" {
+		t.Fatalf("endpoint host = %q, want %q", endpoint.URI.Host, "minio.example.com")
+	}
+	if endpoint.URI.Scheme != "https" {
+		t.Fatalf("endpoint scheme = %q, want https", endpoint.URI.Scheme)
+	}
+}
+
+func TestS3EndpointResolverDisableSSLForcesHTTP(t *testing.T) {
+	r := newS3EndpointResolver("https://minio.example.com", true, false, true)
+
+	region := "us-east-1"
+	endpoint, err := r.ResolveEndpoint(context.Background(), s3.EndpointParameters{Region: &region})
+	if err != nil {
+		t.Fatalf("ResolveEndpoint returned error: %v", err)
+	}
+	if endpoint.URI.Scheme != "http" {
+		t.Fatalf("endpoint scheme = %q, want http when DisableSSL is set", endpoint.URI.Scheme)
+	}
+}
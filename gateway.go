@@ -0,0 +1,284 @@
+package caddys3proxy
+
+import (
+	"encoding/xml"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"go.uber.org/zap"
+)
+
+// s3Namespace is the XML namespace used by the S3 REST API.
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// GatewayAccessKey is a credential accepted by the S3 gateway, optionally
+// restricted to a single bucket and/or key prefix.
+type GatewayAccessKey struct {
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+	Bucket          string `json:"bucket,omitempty"`
+	Prefix          string `json:"prefix,omitempty"`
+}
+
+// allows reports whether this access key may operate on bucket/key.
+func (k GatewayAccessKey) allows(bucket, key string) bool {
+	if k.Bucket != "" && k.Bucket != bucket {
+		return false
+	}
+	if k.Prefix != "" && !strings.HasPrefix(key, k.Prefix) {
+		return false
+	}
+	return true
+}
+
+// gatewayObject is one <Contents> entry of a ListBucketResult.
+type gatewayObject struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+// gatewayCommonPrefix is one <CommonPrefixes> entry of a ListBucketResult.
+type gatewayCommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// listBucketResultV2 is the body of a `GET /{bucket}?list-type=2` response.
+type listBucketResultV2 struct {
+	XMLName               xml.Name              `xml:"ListBucketResult"`
+	Xmlns                 string                `xml:"xmlns,attr"`
+	Name                  string                `xml:"Name"`
+	Prefix                string                `xml:"Prefix"`
+	Delimiter             string                `xml:"Delimiter,omitempty"`
+	MaxKeys               int64                 `xml:"MaxKeys"`
+	KeyCount              int                   `xml:"KeyCount"`
+	IsTruncated           bool                  `xml:"IsTruncated"`
+	ContinuationToken     string                `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string                `xml:"NextContinuationToken,omitempty"`
+	Contents              []gatewayObject       `xml:"Contents"`
+	CommonPrefixes        []gatewayCommonPrefix `xml:"CommonPrefixes"`
+}
+
+// listBucketResultV1 is the body of a legacy `GET /{bucket}` (no list-type)
+// response, keyed off Marker/NextMarker instead of continuation tokens.
+type listBucketResultV1 struct {
+	XMLName        xml.Name              `xml:"ListBucketResult"`
+	Xmlns          string                `xml:"xmlns,attr"`
+	Name           string                `xml:"Name"`
+	Prefix         string                `xml:"Prefix"`
+	Marker         string                `xml:"Marker"`
+	NextMarker     string                `xml:"NextMarker,omitempty"`
+	Delimiter      string                `xml:"Delimiter,omitempty"`
+	MaxKeys        int64                 `xml:"MaxKeys"`
+	IsTruncated    bool                  `xml:"IsTruncated"`
+	Contents       []gatewayObject       `xml:"Contents"`
+	CommonPrefixes []gatewayCommonPrefix `xml:"CommonPrefixes"`
+}
+
+// apiErrorResponse is the standard S3 error body.
+type apiErrorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource"`
+	RequestID string   `xml:"RequestId,omitempty"`
+}
+
+// GatewayHandler authenticates r with SigV4 and serves the S3 REST API
+// (bucket listing, object get/put/delete/head) against p.Bucket.
+func (p S3Proxy) GatewayHandler(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	keyID, err := p.verifySigV4(r)
+	if err != nil {
+		p.log.Debug("gateway:auth-fail",
+			zap.String("r.URL.path", r.URL.Path),
+			zap.String("error", err.Error()),
+		)
+		writeGatewayError(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error(), r.URL.Path)
+		return nil
+	}
+
+	accessKey := p.AccessKeys[keyID]
+	bucket, key := splitGatewayPath(r.URL.Path)
+
+	if !accessKey.allows(bucket, key) {
+		writeGatewayError(w, http.StatusForbidden, "AccessDenied", "access denied for this key", r.URL.Path)
+		return nil
+	}
+
+	if bucket != p.Bucket {
+		writeGatewayError(w, http.StatusNotFound, "NoSuchBucket", "the specified bucket does not exist", r.URL.Path)
+		return nil
+	}
+
+	// key is used verbatim as the S3 key, matching the no-leading-slash
+	// key space gatewayListV2/gatewayListV1 list against - and the one any
+	// real S3 client expects for a bucket populated outside this gateway.
+	switch {
+	case key == "" && r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		return p.gatewayListV2(w, r, bucket)
+	case key == "" && r.Method == http.MethodGet:
+		return p.gatewayListV1(w, r, bucket)
+	case r.Method == http.MethodGet:
+		return p.GetHandler(w, r, key)
+	case r.Method == http.MethodHead:
+		return p.HeadHandler(w, r, key)
+	case r.Method == http.MethodPut:
+		return p.PutHandler(w, r, key)
+	case r.Method == http.MethodDelete:
+		return p.DeleteHandler(w, r, key)
+	default:
+		writeGatewayError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "the specified method is not allowed", r.URL.Path)
+		return nil
+	}
+}
+
+// gatewayListV2 serves `GET /{bucket}?list-type=2`.
+func (p S3Proxy) gatewayListV2(w http.ResponseWriter, r *http.Request, bucket string) error {
+	q := r.URL.Query()
+	prefix := q.Get("prefix")
+	delimiter := q.Get("delimiter")
+	maxKeys := gatewayMaxKeys(q.Get("max-keys"))
+
+	loi := &s3.ListObjectsV2Input{
+		Bucket:  awsv2.String(bucket),
+		Prefix:  awsv2.String(prefix),
+		MaxKeys: awsv2.Int32(int32(maxKeys)),
+	}
+	if delimiter != "" {
+		loi.Delimiter = awsv2.String(delimiter)
+	}
+	if ct := q.Get("continuation-token"); ct != "" {
+		loi.ContinuationToken = awsv2.String(ct)
+	}
+
+	out, err := p.client.ListObjectsV2(r.Context(), loi)
+	if err != nil {
+		p.log.Error("gateway:list-fail",
+			zap.String("bucket", bucket),
+			zap.String("error", err.Error()),
+		)
+		writeGatewayError(w, http.StatusInternalServerError, "InternalError", err.Error(), r.URL.Path)
+		return nil
+	}
+
+	result := listBucketResultV2{
+		Xmlns:                 s3Namespace,
+		Name:                  bucket,
+		Prefix:                prefix,
+		Delimiter:             delimiter,
+		MaxKeys:               maxKeys,
+		KeyCount:              len(out.Contents) + len(out.CommonPrefixes),
+		IsTruncated:           awsv2.ToBool(out.IsTruncated),
+		ContinuationToken:     q.Get("continuation-token"),
+		NextContinuationToken: awsv2.ToString(out.NextContinuationToken),
+	}
+	appendGatewayObjects(&result.Contents, &result.CommonPrefixes, out.Contents, out.CommonPrefixes)
+
+	return writeGatewayXML(w, result)
+}
+
+// gatewayListV1 serves `GET /{bucket}` without list-type, the legacy
+// Marker/NextMarker pagination scheme.
+func (p S3Proxy) gatewayListV1(w http.ResponseWriter, r *http.Request, bucket string) error {
+	q := r.URL.Query()
+	prefix := q.Get("prefix")
+	delimiter := q.Get("delimiter")
+	marker := q.Get("marker")
+	maxKeys := gatewayMaxKeys(q.Get("max-keys"))
+
+	loi := &s3.ListObjectsInput{
+		Bucket:  awsv2.String(bucket),
+		Prefix:  awsv2.String(prefix),
+		Marker:  awsv2.String(marker),
+		MaxKeys: awsv2.Int32(int32(maxKeys)),
+	}
+	if delimiter != "" {
+		loi.Delimiter = awsv2.String(delimiter)
+	}
+
+	out, err := p.client.ListObjects(r.Context(), loi)
+	if err != nil {
+		p.log.Error("gateway:list-fail",
+			zap.String("bucket", bucket),
+			zap.String("error", err.Error()),
+		)
+		writeGatewayError(w, http.StatusInternalServerError, "InternalError", err.Error(), r.URL.Path)
+		return nil
+	}
+
+	result := listBucketResultV1{
+		Xmlns:       s3Namespace,
+		Name:        bucket,
+		Prefix:      prefix,
+		Marker:      marker,
+		NextMarker:  awsv2.ToString(out.NextMarker),
+		Delimiter:   delimiter,
+		MaxKeys:     maxKeys,
+		IsTruncated: awsv2.ToBool(out.IsTruncated),
+	}
+	appendGatewayObjects(&result.Contents, &result.CommonPrefixes, out.Contents, out.CommonPrefixes)
+
+	return writeGatewayXML(w, result)
+}
+
+func appendGatewayObjects(contents *[]gatewayObject, commonPrefixes *[]gatewayCommonPrefix, objs []types.Object, prefixes []types.CommonPrefix) {
+	for _, o := range objs {
+		*contents = append(*contents, gatewayObject{
+			Key:          awsv2.ToString(o.Key),
+			LastModified: awsv2.ToTime(o.LastModified).UTC().Format("2006-01-02T15:04:05.000Z"),
+			ETag:         awsv2.ToString(o.ETag),
+			Size:         awsv2.ToInt64(o.Size),
+			StorageClass: string(o.StorageClass),
+		})
+	}
+	for _, cp := range prefixes {
+		*commonPrefixes = append(*commonPrefixes, gatewayCommonPrefix{Prefix: awsv2.ToString(cp.Prefix)})
+	}
+}
+
+func gatewayMaxKeys(raw string) int64 {
+	if raw == "" {
+		return 1000
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || n <= 0 {
+		return 1000
+	}
+	return n
+}
+
+func writeGatewayXML(w http.ResponseWriter, v interface{}) error {
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write([]byte(xml.Header))
+	return xml.NewEncoder(w).Encode(v)
+}
+
+func writeGatewayError(w http.ResponseWriter, status int, code, message, resource string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	xml.NewEncoder(w).Encode(apiErrorResponse{
+		Code:     code,
+		Message:  message,
+		Resource: resource,
+	})
+}
+
+// splitGatewayPath splits a gateway request path into its bucket and key
+// components: `/{bucket}/{key...}`.
+func splitGatewayPath(p string) (bucket string, key string) {
+	trimmed := strings.TrimPrefix(path.Clean(p), "/")
+	parts := strings.SplitN(trimmed, "/", 2)
+	bucket = parts[0]
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+	return bucket, key
+}
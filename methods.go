@@ -0,0 +1,183 @@
+package caddys3proxy
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"go.uber.org/zap"
+)
+
+// metaHeaderPrefix is the HTTP header prefix carrying S3 object metadata.
+const metaHeaderPrefix = "X-Amz-Meta-"
+
+// PutHandler streams the request body to S3 as fullPath, carrying through
+// the standard representation headers and any X-Amz-Meta-* headers as S3
+// object metadata.
+func (p S3Proxy) PutHandler(w http.ResponseWriter, r *http.Request, fullPath string) error {
+	input := &s3.PutObjectInput{
+		Bucket: awsv2.String(p.Bucket),
+		Key:    awsv2.String(fullPath),
+		Body:   r.Body,
+	}
+
+	headers := r.Header
+	if ct := headers.Get("Content-Type"); ct != "" {
+		input.ContentType = awsv2.String(ct)
+	}
+	if ce := headers.Get("Content-Encoding"); ce != "" {
+		input.ContentEncoding = awsv2.String(ce)
+	}
+	if cc := headers.Get("Cache-Control"); cc != "" {
+		input.CacheControl = awsv2.String(cc)
+	}
+	if cd := headers.Get("Content-Disposition"); cd != "" {
+		input.ContentDisposition = awsv2.String(cd)
+	}
+	if exp := headers.Get("Expires"); exp != "" {
+		if t, err := http.ParseTime(exp); err == nil {
+			input.Expires = awsv2.Time(t)
+		}
+	}
+
+	metadata := map[string]string{}
+	for key := range headers {
+		if strings.HasPrefix(key, metaHeaderPrefix) {
+			metaKey := strings.TrimPrefix(key, metaHeaderPrefix)
+			metadata[metaKey] = headers.Get(key)
+		}
+	}
+	if len(metadata) > 0 {
+		input.Metadata = metadata
+	}
+
+	if p.EnforceContentMD5 {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			p.log.Error("put:fail",
+				zap.String("bucket", p.Bucket),
+				zap.String("key", fullPath),
+				zap.String("error", err.Error()),
+			)
+			return err
+		}
+
+		sum := md5.Sum(body)
+		computed := base64.StdEncoding.EncodeToString(sum[:])
+		if clientMD5 := headers.Get("Content-MD5"); clientMD5 != "" && clientMD5 != computed {
+			p.log.Debug("put:fail",
+				zap.String("bucket", p.Bucket),
+				zap.String("key", fullPath),
+				zap.String("message", "Content-MD5 mismatch"),
+			)
+			http.Error(w, "Content-MD5 mismatch", http.StatusBadRequest)
+			return errors.New("content-md5 mismatch")
+		}
+
+		input.ContentMD5 = awsv2.String(computed)
+		input.Body = bytes.NewReader(body)
+	}
+
+	p.log.Debug("put:attempt",
+		zap.String("bucket", p.Bucket),
+		zap.String("key", fullPath),
+	)
+
+	if _, err := p.uploader.Upload(r.Context(), input); err != nil {
+		p.log.Error("put:fail",
+			zap.String("bucket", p.Bucket),
+			zap.String("key", fullPath),
+			zap.String("error", err.Error()),
+		)
+		return err
+	}
+
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// DeleteHandler removes fullPath from the bucket.
+func (p S3Proxy) DeleteHandler(w http.ResponseWriter, r *http.Request, fullPath string) error {
+	p.log.Debug("delete:attempt",
+		zap.String("bucket", p.Bucket),
+		zap.String("key", fullPath),
+	)
+
+	_, err := p.client.DeleteObject(r.Context(), &s3.DeleteObjectInput{
+		Bucket: awsv2.String(p.Bucket),
+		Key:    awsv2.String(fullPath),
+	})
+	if err != nil {
+		p.log.Error("delete:fail",
+			zap.String("bucket", p.Bucket),
+			zap.String("key", fullPath),
+			zap.String("error", err.Error()),
+		)
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// HeadHandler returns the same headers as writeResponseFromGetObject without
+// a body.
+func (p S3Proxy) HeadHandler(w http.ResponseWriter, r *http.Request, fullPath string) error {
+	p.log.Debug("head:attempt",
+		zap.String("bucket", p.Bucket),
+		zap.String("key", fullPath),
+	)
+
+	obj, err := p.client.HeadObject(r.Context(), &s3.HeadObjectInput{
+		Bucket: awsv2.String(p.Bucket),
+		Key:    awsv2.String(fullPath),
+	})
+	if err != nil {
+		if isNoSuchKey(err) {
+			p.log.Debug("head:miss",
+				zap.String("bucket", p.Bucket),
+				zap.String("key", fullPath),
+			)
+		} else {
+			p.log.Error("head:fail",
+				zap.String("bucket", p.Bucket),
+				zap.String("key", fullPath),
+				zap.String("error", err.Error()),
+			)
+		}
+		return err
+	}
+
+	setStrHeader(w, "Cache-Control", obj.CacheControl)
+	setStrHeader(w, "Content-Disposition", obj.ContentDisposition)
+	setStrHeader(w, "Content-Encoding", obj.ContentEncoding)
+	setStrHeader(w, "Content-Language", obj.ContentLanguage)
+	if override, ok := p.extensionContentType(fullPath); ok {
+		w.Header().Set("Content-Type", override)
+	} else {
+		setStrHeader(w, "Content-Type", obj.ContentType)
+	}
+	setStrHeader(w, "ETag", obj.ETag)
+	setStrHeader(w, "Expires", obj.ExpiresString)
+	setTimeHeader(w, "Last-Modified", obj.LastModified)
+
+	for key, value := range obj.Metadata {
+		if value != "" {
+			w.Header().Set(key, value)
+		}
+	}
+
+	if obj.ContentLength != nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(*obj.ContentLength, 10))
+	}
+
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
@@ -0,0 +1,152 @@
+package caddys3proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"go.uber.org/zap"
+)
+
+// newTestMethodsServer returns an httptest.Server standing in for S3, calling
+// handle for every request so tests can assert on method/path/headers sent
+// by PutHandler/DeleteHandler/HeadHandler.
+func newTestMethodsServer(t *testing.T, handle func(w http.ResponseWriter, r *http.Request)) *httptest.Server {
+	t.Helper()
+
+	ts := httptest.NewServer(http.HandlerFunc(handle))
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func TestPutHandlerUploadsToKey(t *testing.T) {
+	var gotPath string
+	ts := newTestMethodsServer(t, func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		w.Header().Set("ETag", `"abc123"`)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	client := newTestS3Client(ts)
+	p := S3Proxy{
+		Bucket:   "bucket",
+		client:   client,
+		uploader: manager.NewUploader(client),
+		log:      zap.NewNop(),
+	}
+
+	r := httptest.NewRequest(http.MethodPut, "/photos/cat.png", strings.NewReader("meow"))
+	w := httptest.NewRecorder()
+
+	if err := p.PutHandler(w, r, "/photos/cat.png"); err != nil {
+		t.Fatalf("PutHandler returned error: %v", err)
+	}
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+	}
+	// fullPath carries its own leading "/" (the key-space every handler now
+	// shares), so the request path naturally has a doubled slash here.
+	if gotPath != "/bucket//photos/cat.png" {
+		t.Fatalf("uploaded path = %q, want %q", gotPath, "/bucket//photos/cat.png")
+	}
+}
+
+func TestPutHandlerRejectsMismatchedContentMD5(t *testing.T) {
+	ts := newTestMethodsServer(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("S3 should not be called when Content-MD5 fails to verify")
+	})
+
+	client := newTestS3Client(ts)
+	p := S3Proxy{
+		Bucket:            "bucket",
+		client:            client,
+		uploader:          manager.NewUploader(client),
+		EnforceContentMD5: true,
+		log:               zap.NewNop(),
+	}
+
+	r := httptest.NewRequest(http.MethodPut, "/photos/cat.png", strings.NewReader("meow"))
+	r.Header.Set("Content-MD5", "not-the-right-hash")
+	w := httptest.NewRecorder()
+
+	if err := p.PutHandler(w, r, "/photos/cat.png"); err == nil {
+		t.Fatal("expected PutHandler to reject a mismatched Content-MD5")
+	}
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDeleteHandlerRemovesKey(t *testing.T) {
+	var gotPath string
+	ts := newTestMethodsServer(t, func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	p := S3Proxy{Bucket: "bucket", client: newTestS3Client(ts), log: zap.NewNop()}
+
+	r := httptest.NewRequest(http.MethodDelete, "/photos/cat.png", nil)
+	w := httptest.NewRecorder()
+
+	if err := p.DeleteHandler(w, r, "/photos/cat.png"); err != nil {
+		t.Fatalf("DeleteHandler returned error: %v", err)
+	}
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if gotPath != "/bucket//photos/cat.png" {
+		t.Fatalf("deleted path = %q, want %q", gotPath, "/bucket//photos/cat.png")
+	}
+}
+
+func TestHeadHandlerUsesExtensionContentTypeOverGenericObjectContentType(t *testing.T) {
+	ts := newTestMethodsServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("ETag", `"abc123"`)
+		w.Header().Set("Content-Length", "4")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	p := S3Proxy{
+		Bucket:       "bucket",
+		client:       newTestS3Client(ts),
+		ExtensionMap: map[string]string{".wasm": "application/wasm"},
+		log:          zap.NewNop(),
+	}
+
+	r := httptest.NewRequest(http.MethodHead, "/app.wasm", nil)
+	w := httptest.NewRecorder()
+
+	if err := p.HeadHandler(w, r, "/app.wasm"); err != nil {
+		t.Fatalf("HeadHandler returned error: %v", err)
+	}
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/wasm" {
+		t.Fatalf("Content-Type = %q, want %q (matching what GetHandler would report)", ct, "application/wasm")
+	}
+}
+
+func TestHeadHandlerFallsBackToObjectContentType(t *testing.T) {
+	ts := newTestMethodsServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("ETag", `"abc123"`)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	p := S3Proxy{Bucket: "bucket", client: newTestS3Client(ts), log: zap.NewNop()}
+
+	r := httptest.NewRequest(http.MethodHead, "/photos/cat.png", nil)
+	w := httptest.NewRecorder()
+
+	if err := p.HeadHandler(w, r, "/photos/cat.png"); err != nil {
+		t.Fatalf("HeadHandler returned error: %v", err)
+	}
+
+	if ct := w.Header().Get("Content-Type"); ct != "image/png" {
+		t.Fatalf("Content-Type = %q, want %q", ct, "image/png")
+	}
+}
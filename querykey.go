@@ -0,0 +1,127 @@
+package caddys3proxy
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// applyQueryKey maps a request's query string onto s3Key according to
+// p.QueryKeyStrategy:
+//
+//   - "ignore" (the default): the query string is dropped; s3Key is
+//     returned unchanged.
+//   - "sha1": s3Key gets a subdirectory named after the sha1 of the
+//     request's literal RawQuery - the original, pre-chunk0-6 behavior,
+//     kept byte-for-byte for back-compat with existing deployments.
+//   - "sha256": like sha1, but hashes the (optionally allow-listed)
+//     canonicalized query string rather than the literal RawQuery.
+//   - "sorted_raw": like sha256, but the canonicalized query string is
+//     appended literally instead of being hashed.
+//   - "passthrough_to_s3": s3Key is returned unchanged; applyPassthroughQuery
+//     forwards the relevant parameters as real S3 GetObject parameters
+//     instead.
+func (p S3Proxy) applyQueryKey(s3Key string, r *http.Request) string {
+	if len(r.URL.RawQuery) == 0 {
+		return s3Key
+	}
+
+	switch p.QueryKeyStrategy {
+	case "sha1":
+		return path.Join(s3Key, "/", convertSha1(r.URL.RawQuery))
+	case "sha256":
+		return path.Join(s3Key, "/", convertSha256(p.canonicalQuery(r)))
+	case "sorted_raw":
+		if canon := p.canonicalQuery(r); canon != "" {
+			return path.Join(s3Key, "/", canon)
+		}
+		return s3Key
+	default: // "ignore", "passthrough_to_s3", or unset
+		return s3Key
+	}
+}
+
+// canonicalQuery filters r's query parameters down to p.QueryAllowlist (if
+// set), then renders them sorted by key as "k1=v1&k2=v2".
+func (p S3Proxy) canonicalQuery(r *http.Request) string {
+	values := p.allowedQuery(r)
+	if len(values) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		for _, v := range values[k] {
+			if b.Len() > 0 {
+				b.WriteByte('&')
+			}
+			b.WriteString(k)
+			b.WriteByte('=')
+			b.WriteString(v)
+		}
+	}
+	return b.String()
+}
+
+// allowedQuery returns r's query parameters, filtered down to
+// p.QueryAllowlist when it is non-empty.
+func (p S3Proxy) allowedQuery(r *http.Request) url.Values {
+	values := r.URL.Query()
+	if len(p.QueryAllowlist) == 0 {
+		return values
+	}
+
+	allowed := url.Values{}
+	for _, name := range p.QueryAllowlist {
+		if v, ok := values[name]; ok {
+			allowed[name] = v
+		}
+	}
+	return allowed
+}
+
+// applyPassthroughQuery forwards the well-known S3 query parameters
+// versionId, partNumber, and response-content-type from r onto oi, subject
+// to p.QueryAllowlist.
+func (p S3Proxy) applyPassthroughQuery(oi *s3.GetObjectInput, r *http.Request) {
+	q := p.allowedQuery(r)
+
+	if versionID := q.Get("versionId"); versionID != "" {
+		oi.VersionId = awsv2.String(versionID)
+	}
+	if partNumber := q.Get("partNumber"); partNumber != "" {
+		if n, err := strconv.ParseInt(partNumber, 10, 32); err == nil {
+			oi.PartNumber = awsv2.Int32(int32(n))
+		}
+	}
+	if responseContentType := q.Get("response-content-type"); responseContentType != "" {
+		oi.ResponseContentType = awsv2.String(responseContentType)
+	}
+}
+
+func convertSha1(in string) string {
+	h := sha1.New()
+	h.Write([]byte(in))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func convertSha256(in string) string {
+	h := sha256.New()
+	h.Write([]byte(in))
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,57 @@
+package caddys3proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestApplyQueryKeySha1MatchesRawQuery(t *testing.T) {
+	p := S3Proxy{QueryKeyStrategy: "sha1"}
+	r := httptest.NewRequest("GET", "/key?b=2&a=1", nil)
+
+	got := p.applyQueryKey("/key", r)
+	want := "/key/" + convertSha1(r.URL.RawQuery)
+	if got != want {
+		t.Fatalf("applyQueryKey = %q, want %q (sha1 must hash the literal RawQuery for back-compat)", got, want)
+	}
+}
+
+func TestApplyQueryKeyIgnoreDropsQuery(t *testing.T) {
+	p := S3Proxy{QueryKeyStrategy: "ignore"}
+	r := httptest.NewRequest("GET", "/key?a=1", nil)
+
+	if got := p.applyQueryKey("/key", r); got != "/key" {
+		t.Fatalf("applyQueryKey = %q, want %q", got, "/key")
+	}
+}
+
+func TestApplyQueryKeySortedRawIsOrderIndependent(t *testing.T) {
+	p := S3Proxy{QueryKeyStrategy: "sorted_raw"}
+	r1 := httptest.NewRequest("GET", "/key?b=2&a=1", nil)
+	r2 := httptest.NewRequest("GET", "/key?a=1&b=2", nil)
+
+	got1 := p.applyQueryKey("/key", r1)
+	got2 := p.applyQueryKey("/key", r2)
+	if got1 != got2 {
+		t.Fatalf("sorted_raw should be order-independent: %q != %q", got1, got2)
+	}
+}
+
+func TestApplyQueryKeyAllowlistDropsTrackingParams(t *testing.T) {
+	p := S3Proxy{QueryKeyStrategy: "sha256", QueryAllowlist: []string{"version"}}
+	r1 := httptest.NewRequest("GET", "/key?version=1&utm_source=foo", nil)
+	r2 := httptest.NewRequest("GET", "/key?version=1&utm_source=bar", nil)
+
+	if got1, got2 := p.applyQueryKey("/key", r1), p.applyQueryKey("/key", r2); got1 != got2 {
+		t.Fatalf("query_allowlist should ignore utm_source: %q != %q", got1, got2)
+	}
+}
+
+func TestApplyQueryKeyPassthroughLeavesKeyUnchanged(t *testing.T) {
+	p := S3Proxy{QueryKeyStrategy: "passthrough_to_s3"}
+	r := httptest.NewRequest("GET", "/key?versionId=abc", nil)
+
+	if got := p.applyQueryKey("/key", r); got != "/key" {
+		t.Fatalf("applyQueryKey = %q, want %q", got, "/key")
+	}
+}
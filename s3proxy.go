@@ -1,27 +1,40 @@
 package caddys3proxy
 
 import (
-	"crypto/sha1"
-	"encoding/hex"
+	"context"
 	"errors"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 	"go.uber.org/zap"
 	"html/template"
 	"io"
 	"net/http"
+	"os"
 	"path"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 )
 
 func init() {
 	caddy.RegisterModule(S3Proxy{})
+	httpcaddyfile.RegisterHandlerDirective("s3proxy", parseCaddyfileHandlerDirective)
+}
+
+// parseCaddyfileHandlerDirective sets up the s3proxy handler from Caddyfile tokens.
+func parseCaddyfileHandlerDirective(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	p := new(S3Proxy)
+	err := p.UnmarshalCaddyfile(h.Dispenser)
+	return p, err
 }
 
 // S3Proxy implements a proxy to return, set, delete or browse objects from S3
@@ -42,7 +55,86 @@ type S3Proxy struct {
 	// Set this to `true` to enable S3 Accelerate feature.
 	S3UseAccelerate bool `json:"use_accelerate,omitempty"`
 
-	client      *s3.S3
+	// Set this to `true` to render an HTML index when a requested path is a
+	// directory and none of IndexNames exist.
+	EnableBrowse bool `json:"enable_browse,omitempty"`
+
+	// Path to a custom Go html/template used to render directory listings.
+	// If empty, a built-in default template is used.
+	BrowseTemplate string `json:"browse_template,omitempty"`
+
+	// The list of index file names to look for when a request path ends in
+	// "/". Defaults to `[]string{"index.html"}`.
+	IndexNames []string `json:"index_names,omitempty"`
+
+	// The list of HTTP methods this instance will serve. Defaults to
+	// `[]string{"GET", "HEAD"}`.
+	Methods []string `json:"methods,omitempty"`
+
+	// Set this to `true` to compute an MD5 sum of the upload body, send it
+	// to S3 as Content-MD5, and reject the request with 400 if the client
+	// supplied a Content-MD5 that doesn't match.
+	EnforceContentMD5 bool `json:"enforce_content_md5,omitempty"`
+
+	// The size, in bytes, of each part in a multipart upload. Defaults to
+	// manager.DefaultUploadPartSize.
+	PartSize int64 `json:"part_size,omitempty"`
+
+	// The number of parts to upload concurrently. Defaults to
+	// manager.DefaultUploadConcurrency.
+	Concurrency int `json:"concurrency,omitempty"`
+
+	// Set this to `true` to serve an S3-compatible REST API to clients (so
+	// tools such as `aws s3`, `s3cmd`, or rclone can point at this proxy),
+	// authenticated with AWS SigV4 against AccessKeys.
+	S3Gateway bool `json:"s3_gateway,omitempty"`
+
+	// Credentials accepted by the S3 gateway, keyed by AccessKeyID.
+	AccessKeys map[string]GatewayAccessKey `json:"access_keys,omitempty"`
+
+	// Use path-style addressing (`endpoint/bucket/key`) instead of
+	// virtual-host addressing (`bucket.endpoint/key`). Required for most
+	// non-AWS S3 implementations such as MinIO or Ceph.
+	UsePathStyle bool `json:"use_path_style,omitempty"`
+
+	// Set this to `true` to talk to Endpoint over plain HTTP.
+	DisableSSL bool `json:"disable_ssl,omitempty"`
+
+	// How to obtain AWS credentials. If unset, the default credential
+	// chain (environment, shared config, EC2/ECS metadata, ...) is used.
+	Credentials CredentialsConfig `json:"credentials,omitempty"`
+
+	// Set this to `true` to sniff the Content-Type from the first 512 bytes
+	// of the object body whenever S3 returns no Content-Type, or one listed
+	// in GenericContentTypes.
+	SniffContentType bool `json:"sniff_content_type,omitempty"`
+
+	// Content types considered too generic to trust, triggering a sniff
+	// when SniffContentType is enabled. Defaults to
+	// `[]string{"application/octet-stream"}`.
+	GenericContentTypes []string `json:"generic_content_types,omitempty"`
+
+	// Overrides Content-Type by file extension (e.g. ".wasm" ->
+	// "application/wasm"), applied before sniffing.
+	ExtensionMap map[string]string `json:"extension_map,omitempty"`
+
+	// Set this to `true` to gzip the response body on the fly when the
+	// client's Accept-Encoding includes gzip and the object has no
+	// Content-Encoding of its own.
+	AutoEncoding bool `json:"auto_encoding,omitempty"`
+
+	// How a request's query string maps onto the S3 key, one of "ignore"
+	// (default), "sha1", "sha256", "sorted_raw", or "passthrough_to_s3".
+	// See applyQueryKey for the semantics of each.
+	QueryKeyStrategy string `json:"query_key_strategy,omitempty"`
+
+	// If non-empty, only these query parameter names participate in
+	// QueryKeyStrategy (or are forwarded to S3 under passthrough_to_s3);
+	// every other parameter is dropped.
+	QueryAllowlist []string `json:"query_allowlist,omitempty"`
+
+	client      *s3.Client
+	uploader    *manager.Uploader
 	dirTemplate *template.Template
 	log         *zap.Logger
 }
@@ -55,6 +147,212 @@ func (S3Proxy) CaddyModule() caddy.ModuleInfo {
 	}
 }
 
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens. Syntax:
+//
+//	s3proxy {
+//	    root <path>
+//	    region <region>
+//	    bucket <bucket>
+//	    endpoint <endpoint>
+//	    use_accelerate
+//	    enable_browse
+//	    browse_template <path>
+//	    index_names <name> [<name>...]
+//	    methods <method> [<method>...]
+//	    enforce_content_md5
+//	    part_size <bytes>
+//	    concurrency <n>
+//	    s3_gateway
+//	    access_keys {
+//	        <access_key_id> <secret_access_key> [<bucket> [<prefix>]]
+//	    }
+//	    use_path_style
+//	    disable_ssl
+//	    credentials static <access_key_id> <secret_access_key> [<session_token>]
+//	    credentials ec2_instance_role
+//	    credentials web_identity <role_arn> <token_file>
+//	    credentials profile <name>
+//	    credentials assume_role <role_arn> [<external_id>]
+//	    sniff_content_type
+//	    generic_content_types <type> [<type>...]
+//	    extension_map {
+//	        <.ext> <content-type>
+//	    }
+//	    auto_encoding
+//	    query_key_strategy ignore|sha1|sha256|sorted_raw|passthrough_to_s3
+//	    query_allowlist <param> [<param>...]
+//	}
+func (p *S3Proxy) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	for d.Next() {
+		for d.NextBlock(0) {
+			switch d.Val() {
+			case "root":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.Root = d.Val()
+			case "region":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.Region = d.Val()
+			case "bucket":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.Bucket = d.Val()
+			case "endpoint":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.Endpoint = d.Val()
+			case "use_accelerate":
+				p.S3UseAccelerate = true
+			case "enable_browse":
+				p.EnableBrowse = true
+			case "browse_template":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				p.BrowseTemplate = d.Val()
+			case "index_names":
+				p.IndexNames = d.RemainingArgs()
+				if len(p.IndexNames) == 0 {
+					return d.ArgErr()
+				}
+			case "methods":
+				p.Methods = d.RemainingArgs()
+				if len(p.Methods) == 0 {
+					return d.ArgErr()
+				}
+				for i, m := range p.Methods {
+					p.Methods[i] = strings.ToUpper(m)
+				}
+			case "enforce_content_md5":
+				p.EnforceContentMD5 = true
+			case "part_size":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				n, err := strconv.ParseInt(d.Val(), 10, 64)
+				if err != nil {
+					return d.Errf("invalid part_size: %v", err)
+				}
+				p.PartSize = n
+			case "concurrency":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				n, err := strconv.Atoi(d.Val())
+				if err != nil {
+					return d.Errf("invalid concurrency: %v", err)
+				}
+				p.Concurrency = n
+			case "s3_gateway":
+				p.S3Gateway = true
+			case "access_keys":
+				if p.AccessKeys == nil {
+					p.AccessKeys = map[string]GatewayAccessKey{}
+				}
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					accessKeyID := d.Val()
+					args := d.RemainingArgs()
+					if len(args) == 0 {
+						return d.ArgErr()
+					}
+					key := GatewayAccessKey{SecretAccessKey: args[0]}
+					if len(args) > 1 {
+						key.Bucket = args[1]
+					}
+					if len(args) > 2 {
+						key.Prefix = args[2]
+					}
+					p.AccessKeys[accessKeyID] = key
+				}
+			case "use_path_style":
+				p.UsePathStyle = true
+			case "disable_ssl":
+				p.DisableSSL = true
+			case "credentials":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				switch args[0] {
+				case "static":
+					if len(args) < 3 {
+						return d.ArgErr()
+					}
+					p.Credentials = CredentialsConfig{Kind: "static", AccessKeyID: args[1], SecretAccessKey: args[2]}
+					if len(args) > 3 {
+						p.Credentials.SessionToken = args[3]
+					}
+				case "ec2_instance_role":
+					p.Credentials = CredentialsConfig{Kind: "ec2_instance_role"}
+				case "web_identity":
+					if len(args) != 3 {
+						return d.ArgErr()
+					}
+					p.Credentials = CredentialsConfig{Kind: "web_identity", RoleARN: args[1], TokenFile: args[2]}
+				case "profile":
+					if len(args) != 2 {
+						return d.ArgErr()
+					}
+					p.Credentials = CredentialsConfig{Kind: "profile", Profile: args[1]}
+				case "assume_role":
+					if len(args) < 2 {
+						return d.ArgErr()
+					}
+					p.Credentials = CredentialsConfig{Kind: "assume_role", RoleARN: args[1]}
+					if len(args) > 2 {
+						p.Credentials.ExternalID = args[2]
+					}
+				default:
+					return d.Errf("unknown credentials kind %q", args[0])
+				}
+			case "sniff_content_type":
+				p.SniffContentType = true
+			case "generic_content_types":
+				p.GenericContentTypes = d.RemainingArgs()
+				if len(p.GenericContentTypes) == 0 {
+					return d.ArgErr()
+				}
+			case "extension_map":
+				if p.ExtensionMap == nil {
+					p.ExtensionMap = map[string]string{}
+				}
+				for nesting := d.Nesting(); d.NextBlock(nesting); {
+					ext := d.Val()
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					p.ExtensionMap[ext] = d.Val()
+				}
+			case "auto_encoding":
+				p.AutoEncoding = true
+			case "query_key_strategy":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				switch d.Val() {
+				case "ignore", "sha1", "sha256", "sorted_raw", "passthrough_to_s3":
+					p.QueryKeyStrategy = d.Val()
+				default:
+					return d.Errf("unknown query_key_strategy %q", d.Val())
+				}
+			case "query_allowlist":
+				p.QueryAllowlist = d.RemainingArgs()
+				if len(p.QueryAllowlist) == 0 {
+					return d.ArgErr()
+				}
+			default:
+				return d.ArgErr()
+			}
+		}
+	}
+	return nil
+}
+
 func (p *S3Proxy) Provision(ctx caddy.Context) (err error) {
 	p.log = ctx.Logger(p)
 
@@ -62,107 +360,186 @@ func (p *S3Proxy) Provision(ctx caddy.Context) (err error) {
 		p.Root = "{http.vars.root}"
 	}
 
-	var config aws.Config
+	if len(p.IndexNames) == 0 {
+		p.IndexNames = []string{"index.html"}
+	}
+
+	if len(p.Methods) == 0 {
+		p.Methods = []string{http.MethodGet, http.MethodHead}
+	}
+
+	if len(p.GenericContentTypes) == 0 {
+		p.GenericContentTypes = []string{"application/octet-stream"}
+	}
+
+	if p.QueryKeyStrategy == "" {
+		p.QueryKeyStrategy = "ignore"
+	}
+
+	if p.EnableBrowse {
+		tmplText := defaultBrowseTemplate
+		if p.BrowseTemplate != "" {
+			b, err := os.ReadFile(p.BrowseTemplate)
+			if err != nil {
+				p.log.Error("could not read browse_template",
+					zap.String("path", p.BrowseTemplate),
+					zap.String("error", err.Error()),
+				)
+				return err
+			}
+			tmplText = string(b)
+		}
+
+		p.dirTemplate, err = template.New("browse").Parse(tmplText)
+		if err != nil {
+			p.log.Error("could not parse browse template",
+				zap.String("error", err.Error()),
+			)
+			return err
+		}
+	}
+
+	var loadOpts []func(*config.LoadOptions) error
 
-	// If Region is not specified NewSession will look for it from an env value AWS_REGION
+	// If Region is not specified LoadDefaultConfig will look for it from an
+	// env value AWS_REGION or the shared config.
 	if p.Region != "" {
-		config.Region = aws.String(p.Region)
+		loadOpts = append(loadOpts, config.WithRegion(p.Region))
 	}
 
-	if p.Endpoint != "" {
-		config.Endpoint = aws.String(p.Endpoint)
+	// Load a bootstrap config with the default credential chain, which is
+	// all that the web_identity and assume_role credential kinds need to
+	// construct their own STS client.
+	bootstrapCfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		p.log.Error("could not load AWS config",
+			zap.String("error", err.Error()),
+		)
+		return err
 	}
 
-	if p.S3UseAccelerate {
-		config.S3UseAccelerate = aws.Bool(p.S3UseAccelerate)
+	provider, err := p.Credentials.provider(ctx, bootstrapCfg)
+	if err != nil {
+		p.log.Error("could not set up AWS credentials",
+			zap.String("error", err.Error()),
+		)
+		return err
+	}
+	if provider != nil {
+		loadOpts = append(loadOpts, config.WithCredentialsProvider(provider))
 	}
 
-	sess, err := session.NewSession(&config)
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
 	if err != nil {
-		p.log.Error("could not create AWS session",
+		p.log.Error("could not create AWS config",
 			zap.String("error", err.Error()),
 		)
 		return err
 	}
 
+	resolver := newS3EndpointResolver(p.Endpoint, p.UsePathStyle, p.S3UseAccelerate, p.DisableSSL)
+
 	// Create S3 service client
-	p.client = s3.New(sess)
+	p.client = s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = p.UsePathStyle
+		o.UseAccelerate = p.S3UseAccelerate
+		o.EndpointResolverV2 = resolver
+	})
+	p.uploader = manager.NewUploader(p.client, func(u *manager.Uploader) {
+		if p.PartSize > 0 {
+			u.PartSize = p.PartSize
+		}
+		if p.Concurrency > 0 {
+			u.Concurrency = p.Concurrency
+		}
+	})
 	p.log.Info("S3 proxy initialized for bucket: " + p.Bucket)
 	p.log.Debug("config values",
 		zap.String("endpoint", p.Endpoint),
 		zap.String("region", p.Region),
 		zap.Bool("use_accelerate", p.S3UseAccelerate),
+		zap.Bool("use_path_style", p.UsePathStyle),
+		zap.Strings("methods", p.Methods),
 	)
 
 	return nil
 }
 
-func (p S3Proxy) getS3Object(bucket string, key string, r *http.Request, w http.ResponseWriter) (*s3.GetObjectOutput, error) {
+// methodAllowed reports whether method is in the configured allow-list.
+func (p S3Proxy) methodAllowed(method string) bool {
+	for _, m := range p.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (p S3Proxy) getS3Object(ctx context.Context, bucket string, key string, r *http.Request, w http.ResponseWriter) (*s3.GetObjectOutput, error) {
 	oi := &s3.GetObjectInput{
-		Bucket: aws.String(bucket),
-		Key:    aws.String(key),
+		Bucket: awsv2.String(bucket),
+		Key:    awsv2.String(key),
 	}
 	headers := r.Header
 	if rg := headers.Get("Range"); rg != "" {
-		oi = oi.SetRange(rg)
+		oi.Range = awsv2.String(rg)
 	}
 	if ifMatch := headers.Get("If-Match"); ifMatch != "" {
-		oi = oi.SetIfMatch(ifMatch)
+		oi.IfMatch = awsv2.String(ifMatch)
 	}
 	if ifNoneMatch := headers.Get("If-None-Match"); ifNoneMatch != "" {
-		oi = oi.SetIfNoneMatch(ifNoneMatch)
+		oi.IfNoneMatch = awsv2.String(ifNoneMatch)
 	}
 	if ifModifiedSince := headers.Get("If-Modified-Since"); ifModifiedSince != "" {
-		t, err := time.Parse(http.TimeFormat, ifModifiedSince)
-		if err == nil {
-			oi = oi.SetIfModifiedSince(t)
+		if t, err := time.Parse(http.TimeFormat, ifModifiedSince); err == nil {
+			oi.IfModifiedSince = awsv2.Time(t)
 		}
 	}
 	if ifUnmodifiedSince := headers.Get("If-Unmodified-Since"); ifUnmodifiedSince != "" {
-		t, err := time.Parse(http.TimeFormat, ifUnmodifiedSince)
-		if err == nil {
-			oi = oi.SetIfUnmodifiedSince(t)
+		if t, err := time.Parse(http.TimeFormat, ifUnmodifiedSince); err == nil {
+			oi.IfUnmodifiedSince = awsv2.Time(t)
 		}
 	}
 
+	if p.QueryKeyStrategy == "passthrough_to_s3" {
+		p.applyPassthroughQuery(oi, r)
+	}
+
 	p.log.Debug("cache:attempt",
 		zap.String("bucket", bucket),
 		zap.String("key", key),
 	)
 
-	obj, err := p.client.GetObject(oi)
+	obj, err := p.client.GetObject(ctx, oi)
 
 	if err != nil {
-		// Make the err a caddyErr if it is not already
-		awsErr, isAwsErr := err.(awserr.Error)
-
-		if isAwsErr {
-			switch awsErr.Code() {
-			case "NotModified":
-				p.log.Debug("cache:hit",
-					zap.String("bucket", bucket),
-					zap.String("key", key),
-					zap.String("code", awsErr.Code()),
-				)
-				w.WriteHeader(304)
-				return obj, nil
-			case "NoSuchKey":
-				p.log.Debug("cache:miss",
-					zap.String("bucket", bucket),
-					zap.String("key", key),
-					zap.String("code", awsErr.Code()),
-					zap.String("error", awsErr.Error()),
-				)
-			default:
-				p.log.Error("cache:fail",
-					zap.String("bucket", bucket),
-					zap.String("key", key),
-					zap.String("code", awsErr.Code()),
-					zap.String("error", awsErr.Error()),
-				)
-			}
+		var noSuchKey *types.NoSuchKey
+		var apiErr smithy.APIError
 
-		} else {
+		switch {
+		case errors.As(err, &noSuchKey):
+			p.log.Debug("cache:miss",
+				zap.String("bucket", bucket),
+				zap.String("key", key),
+				zap.String("code", noSuchKey.ErrorCode()),
+				zap.String("error", noSuchKey.ErrorMessage()),
+			)
+		case errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotModified":
+			p.log.Debug("cache:hit",
+				zap.String("bucket", bucket),
+				zap.String("key", key),
+				zap.String("code", apiErr.ErrorCode()),
+			)
+			w.WriteHeader(304)
+			return obj, nil
+		case errors.As(err, &apiErr):
+			p.log.Error("cache:fail",
+				zap.String("bucket", bucket),
+				zap.String("key", key),
+				zap.String("code", apiErr.ErrorCode()),
+				zap.String("error", apiErr.ErrorMessage()),
+			)
+		default:
 			p.log.Error("cache:fail",
 				zap.String("bucket", bucket),
 				zap.String("key", key),
@@ -173,7 +550,7 @@ func (p S3Proxy) getS3Object(bucket string, key string, r *http.Request, w http.
 		return obj, err
 	}
 
-	if *obj.ContentLength == 0 {
+	if obj.ContentLength == nil || *obj.ContentLength == 0 {
 		p.log.Error("cache:fail",
 			zap.String("bucket", bucket),
 			zap.String("key", key),
@@ -202,32 +579,59 @@ func joinPath(root string, uriPath string) string {
 	return newPath
 }
 
-func (p S3Proxy) writeResponseFromGetObject(w http.ResponseWriter, obj *s3.GetObjectOutput) error {
+func (p S3Proxy) writeResponseFromGetObject(w http.ResponseWriter, r *http.Request, obj *s3.GetObjectOutput, key string) error {
 	// Copy headers from AWS response to our response
 	setStrHeader(w, "Cache-Control", obj.CacheControl)
 	setStrHeader(w, "Content-Disposition", obj.ContentDisposition)
 	setStrHeader(w, "Content-Encoding", obj.ContentEncoding)
 	setStrHeader(w, "Content-Language", obj.ContentLanguage)
 	setStrHeader(w, "Content-Range", obj.ContentRange)
-	setStrHeader(w, "Content-Type", obj.ContentType)
 	setStrHeader(w, "ETag", obj.ETag)
-	setStrHeader(w, "Expires", obj.Expires)
+	setStrHeader(w, "Expires", obj.ExpiresString)
 	setTimeHeader(w, "Last-Modified", obj.LastModified)
 
 	// Adds all custom headers which where used on this object
-	for key, value := range obj.Metadata {
-		setStrHeader(w, key, value)
+	for metaKey, value := range obj.Metadata {
+		if value != "" {
+			w.Header().Set(metaKey, value)
+		}
 	}
 
 	w.Header().Set("X-Cache-S3", "hit")
 
-	var err error
-	if obj.Body != nil {
-		// io.Copy will set Content-Length
-		w.Header().Del("Content-Length")
-		_, err = io.Copy(w, obj.Body)
+	body := obj.Body
+	contentType := awsv2.ToString(obj.ContentType)
+
+	if override, ok := p.extensionContentType(key); ok {
+		contentType = override
+	} else if p.SniffContentType && body != nil && p.isGenericContentType(contentType) {
+		var err error
+		contentType, body, err = sniffContentType(body)
+		if err != nil {
+			return err
+		}
 	}
 
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+
+	if body == nil {
+		return nil
+	}
+
+	// io.Copy (and the gzip path below) will set Content-Length.
+	w.Header().Del("Content-Length")
+
+	if p.AutoEncoding && awsv2.ToString(obj.ContentEncoding) == "" && acceptsGzip(r) {
+		w.Header().Set("Content-Encoding", "gzip")
+		if etag := w.Header().Get("ETag"); etag != "" {
+			w.Header().Set("ETag", gzipETag(etag))
+		}
+		return gzipCopy(w, body)
+	}
+
+	_, err := io.Copy(w, body)
 	return err
 }
 
@@ -239,7 +643,11 @@ func (p S3Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhtt
 		zap.String("r.URL.RawQuery", r.URL.RawQuery),
 	)
 
-	if r.Method != http.MethodGet { // As of now only support GET requests
+	if p.S3Gateway {
+		return p.GatewayHandler(w, r, next)
+	}
+
+	if !p.methodAllowed(r.Method) {
 		p.log.Debug("cache:miss",
 			zap.String("r.method", r.Method),
 			zap.String("r.URL.path", r.URL.Path),
@@ -255,7 +663,18 @@ func (p S3Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhtt
 
 	var err error
 
-	err = p.GetHandler(w, r, fullPath)
+	switch r.Method {
+	case http.MethodGet:
+		err = p.GetHandler(w, r, fullPath)
+	case http.MethodHead:
+		err = p.HeadHandler(w, r, fullPath)
+	case http.MethodPut:
+		err = p.PutHandler(w, r, fullPath)
+	case http.MethodDelete:
+		err = p.DeleteHandler(w, r, fullPath)
+	default:
+		return next.ServeHTTP(w, r)
+	}
 
 	if err == nil {
 		return nil
@@ -267,29 +686,38 @@ func (p S3Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhtt
 func (p S3Proxy) GetHandler(w http.ResponseWriter, r *http.Request, fullPath string) error {
 	var obj *s3.GetObjectOutput
 	var err error
-	var defaultIndex = "index.html"
-	var s3Key = fullPath
 
-	if strings.HasSuffix(fullPath, "/") { // If we have a trailing-slash, then use the defaultIndex
-		s3Key = path.Join(s3Key, defaultIndex)
+	if !strings.HasSuffix(fullPath, "/") {
+		obj, err = p.getS3Object(r.Context(), p.Bucket, p.applyQueryKey(fullPath, r), r, w)
+		if err != nil {
+			return err
+		}
+		return p.writeResponseFromGetObject(w, r, obj, fullPath)
 	}
 
-	if len(r.URL.RawQuery) > 0 { // RawQuery is converted to sha1() and put in a subdirectory
-		s3Key = path.Join(s3Key, "/", convertSha1(r.URL.RawQuery))
+	// fullPath is a directory - try each of the configured index names in turn.
+	for _, index := range p.IndexNames {
+		s3Key := path.Join(fullPath, index)
+		obj, err = p.getS3Object(r.Context(), p.Bucket, p.applyQueryKey(s3Key, r), r, w)
+		if err == nil {
+			return p.writeResponseFromGetObject(w, r, obj, s3Key)
+		}
+		if !isNoSuchKey(err) {
+			return err
+		}
 	}
 
-	obj, err = p.getS3Object(p.Bucket, s3Key, r, w)
-	if err != nil {
-		return err
+	if p.EnableBrowse {
+		return p.ListHandler(w, r, fullPath)
 	}
 
-	return p.writeResponseFromGetObject(w, obj)
+	return err
 }
 
-func convertSha1(in string) string {
-	h := sha1.New()
-	h.Write([]byte(in))
-	return hex.EncodeToString(h.Sum(nil))
+// isNoSuchKey reports whether err is an AWS NoSuchKey error.
+func isNoSuchKey(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	return errors.As(err, &noSuchKey)
 }
 
 func setStrHeader(w http.ResponseWriter, key string, value *string) {
@@ -303,3 +731,10 @@ func setTimeHeader(w http.ResponseWriter, key string, value *time.Time) {
 		w.Header().Set(key, value.UTC().Format(http.TimeFormat))
 	}
 }
+
+// Interface guards
+var (
+	_ caddy.Provisioner           = (*S3Proxy)(nil)
+	_ caddyhttp.MiddlewareHandler = (*S3Proxy)(nil)
+	_ caddyfile.Unmarshaler       = (*S3Proxy)(nil)
+)
@@ -0,0 +1,20 @@
+package caddys3proxy
+
+import (
+	"net/http/httptest"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// newTestS3Client builds an s3.Client that talks to ts instead of real AWS,
+// for tests that exercise handlers calling through p.client.
+func newTestS3Client(ts *httptest.Server) *s3.Client {
+	return s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  awsv2.AnonymousCredentials{},
+		BaseEndpoint: awsv2.String(ts.URL),
+		UsePathStyle: true,
+		HTTPClient:   ts.Client(),
+	})
+}
@@ -0,0 +1,138 @@
+package caddys3proxy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signedPutRequest builds an httptest PUT request independently signed with
+// AWS4-HMAC-SHA256 over body, the way a real S3 client would.
+func signedPutRequest(t *testing.T, accessKeyID, secret string, body []byte) *http.Request {
+	t.Helper()
+
+	const (
+		host    = "example.com"
+		region  = "us-east-1"
+		service = "s3"
+	)
+
+	amzDate := time.Now().UTC().Format(sigV4DateFormat)
+	date := amzDate[:8]
+	payloadHash := hex.EncodeToString(sha256Sum(body))
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodPut,
+		"/bucket/key",
+		"",
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		amzDate,
+		scope,
+		hex.EncodeToString(sha256Sum([]byte(canonicalRequest))),
+	}, "\n")
+
+	dateKey := hmacSum([]byte("AWS4"+secret), date)
+	regionKey := hmacSum(dateKey, region)
+	serviceKey := hmacSum(regionKey, service)
+	signingKey := hmacSum(serviceKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSum(signingKey, stringToSign))
+
+	auth := sigV4Algorithm + " Credential=" + accessKeyID + "/" + scope +
+		", SignedHeaders=" + signedHeaders + ", Signature=" + signature
+
+	req := httptest.NewRequest(http.MethodPut, "/bucket/key", io.NopCloser(bytes.NewReader(body)))
+	req.Host = host
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	return req
+}
+
+func sha256Sum(b []byte) []byte {
+	sum := sha256.Sum256(b)
+	return sum[:]
+}
+
+func hmacSum(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func TestVerifySigV4HeaderAcceptsMatchingBody(t *testing.T) {
+	body := []byte("hello world")
+	req := signedPutRequest(t, "AKID", "secret", body)
+
+	p := S3Proxy{AccessKeys: map[string]GatewayAccessKey{"AKID": {SecretAccessKey: "secret"}}}
+
+	keyID, err := p.verifySigV4Header(req, req.Header.Get("Authorization"))
+	if err != nil {
+		t.Fatalf("verifySigV4Header returned error: %v", err)
+	}
+	if keyID != "AKID" {
+		t.Fatalf("got access key %q, want AKID", keyID)
+	}
+
+	got, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("reading restored body: %v", err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Fatalf("body after verification = %q, want %q", got, body)
+	}
+}
+
+func TestVerifySigV4HeaderRejectsSwappedBody(t *testing.T) {
+	req := signedPutRequest(t, "AKID", "secret", []byte("hello world"))
+
+	// Swap the body for something else without re-signing - the signature
+	// and X-Amz-Content-Sha256 header still describe the original bytes.
+	req.Body = io.NopCloser(bytes.NewReader([]byte("attacker-controlled payload")))
+
+	p := S3Proxy{AccessKeys: map[string]GatewayAccessKey{"AKID": {SecretAccessKey: "secret"}}}
+
+	if _, err := p.verifySigV4Header(req, req.Header.Get("Authorization")); err != nil {
+		t.Fatalf("verifySigV4Header returned error: %v", err)
+	}
+
+	// The mismatch isn't known until the swapped body is fully streamed -
+	// that's what protects large uploads from being buffered into memory
+	// just to authenticate them.
+	if _, err := io.ReadAll(req.Body); err == nil {
+		t.Fatal("expected reading the verified body to surface the X-Amz-Content-Sha256 mismatch")
+	}
+}
+
+func TestVerifyPayloadHashSkipsUnsignedPayload(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPut, "/bucket/key", io.NopCloser(bytes.NewReader([]byte("anything"))))
+	if err := verifyPayloadHash(req, "UNSIGNED-PAYLOAD"); err != nil {
+		t.Fatalf("expected UNSIGNED-PAYLOAD to skip verification, got: %v", err)
+	}
+}
+
+func TestVerifyPayloadHashRejectsStreamingSentinel(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPut, "/bucket/key", io.NopCloser(bytes.NewReader([]byte("anything"))))
+	if err := verifyPayloadHash(req, "STREAMING-UNSIGNED-PAYLOAD-TRAILER"); err == nil {
+		t.Fatal("expected chunked STREAMING-* payload hashes to be rejected as unsupported")
+	}
+}